@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Config controls the batching, concurrency and retry behavior of
+// firestoreSink.
+type Config struct {
+	// MaxInFlight caps the number of batch commits in flight at once.
+	MaxInFlight int
+	// BatchSize is the number of documents written per Firestore commit.
+	// Firestore rejects batches larger than 500 writes.
+	BatchSize int
+	// MaxRetries is the number of retry attempts for a batch commit that
+	// fails with a retryable error, not counting the initial attempt.
+	MaxRetries int
+	// InitialBackoff is the delay before the first retry; it doubles on
+	// each subsequent attempt.
+	InitialBackoff time.Duration
+}
+
+// DefaultConfig returns the Config used when the caller has no special
+// requirements.
+func DefaultConfig() Config {
+	return Config{
+		MaxInFlight:    8,
+		BatchSize:      500,
+		MaxRetries:     5,
+		InitialBackoff: 200 * time.Millisecond,
+	}
+}
+
+// WriteResult reports the outcome of writing a single document to
+// Firestore.
+type WriteResult struct {
+	DocID string
+	Err   error
+}
+
+// WriteSummary aggregates the per-document results of a Store call.
+type WriteSummary struct {
+	Succeeded []string
+	Failed    []WriteResult
+}
+
+// retryableCodes are the gRPC status codes firestoreSink will retry with
+// backoff; anything else is treated as permanent.
+var retryableCodes = map[codes.Code]bool{
+	codes.Unavailable:       true,
+	codes.DeadlineExceeded:  true,
+	codes.ResourceExhausted: true,
+	codes.Aborted:           true,
+}
+
+// firestoreSink is a Sink that writes records to the posts collection in
+// Firestore using bounded, concurrent batch commits with retry/backoff on
+// transient errors.
+type firestoreSink struct {
+	cfg Config
+}
+
+// maxBatchSize is the largest number of writes Firestore accepts in a
+// single batch commit.
+const maxBatchSize = 500
+
+// chunkRecords splits data into slices of at most size records each. A
+// non-positive or too-large size is clamped rather than trusted, since it
+// would otherwise loop forever or get rejected by Firestore.
+func chunkRecords(data []APIResponse, size int) [][]APIResponse {
+	if size <= 0 || size > maxBatchSize {
+		size = maxBatchSize
+	}
+	var chunks [][]APIResponse
+	for size < len(data) {
+		data, chunks = data[size:], append(chunks, data[:size:size])
+	}
+	return append(chunks, data)
+}
+
+// commitBatch writes one batch of records to the posts collection,
+// retrying the whole batch on retryable errors with exponential backoff.
+func commitBatch(ctx context.Context, client *firestore.Client, cfg Config, records []APIResponse) []WriteResult {
+	results := make([]WriteResult, len(records))
+	for i, record := range records {
+		results[i] = WriteResult{DocID: strconv.Itoa(record.ID)}
+	}
+
+	backoff := cfg.InitialBackoff
+	var lastErr error
+	for attempt := 0; attempt <= cfg.MaxRetries; attempt++ {
+		batch := client.Batch()
+		for i, record := range records {
+			batch.Set(client.Collection("posts").Doc(results[i].DocID), record)
+		}
+
+		if _, err := batch.Commit(ctx); err == nil {
+			return results
+		} else {
+			lastErr = err
+		}
+
+		if !retryableCodes[status.Code(lastErr)] || attempt == cfg.MaxRetries {
+			break
+		}
+
+		log.Printf("retryable error committing batch (attempt %d/%d): %v", attempt+1, cfg.MaxRetries, lastErr)
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			lastErr = ctx.Err()
+			attempt = cfg.MaxRetries
+		}
+	}
+
+	for i := range results {
+		results[i].Err = lastErr
+	}
+	return results
+}
+
+// client creates a Firestore client for the project named by
+// FIRESTORE_PROJECT, logging when a FIRESTORE_EMULATOR_HOST override is in
+// effect.
+func (s firestoreSink) client(ctx context.Context) (*firestore.Client, error) {
+	projectID := os.Getenv("FIRESTORE_PROJECT")
+	if projectID == "" {
+		return nil, fmt.Errorf("FIRESTORE_PROJECT environment variable not set")
+	}
+
+	client, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("firestore client creation failed: %v", err)
+	}
+
+	if emulatorHost := os.Getenv("FIRESTORE_EMULATOR_HOST"); emulatorHost != "" {
+		log.Printf("Using Firestore emulator at %s", emulatorHost)
+	}
+
+	return client, nil
+}
+
+// Store implements Sink.
+func (s firestoreSink) Store(ctx context.Context, records []APIResponse) (WriteSummary, error) {
+	client, err := s.client(ctx)
+	if err != nil {
+		return WriteSummary{}, err
+	}
+	defer client.Close()
+
+	batches := chunkRecords(records, s.cfg.BatchSize)
+
+	var (
+		mu      sync.Mutex
+		summary WriteSummary
+		wg      sync.WaitGroup
+	)
+	sem := make(chan struct{}, s.cfg.MaxInFlight)
+
+	for _, batch := range batches {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(batch []APIResponse) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			results := commitBatch(ctx, client, s.cfg, batch)
+
+			mu.Lock()
+			defer mu.Unlock()
+			for _, result := range results {
+				if result.Err != nil {
+					summary.Failed = append(summary.Failed, result)
+				} else {
+					summary.Succeeded = append(summary.Succeeded, result.DocID)
+				}
+			}
+		}(batch)
+	}
+	wg.Wait()
+
+	return summary, nil
+}
+
+// Connect implements ConnectableSink. It opens a single Firestore client
+// that an incremental sync reuses across GetSyncMeta, StoreIncremental and
+// SetSyncMeta instead of dialing one per call.
+func (s firestoreSink) Connect(ctx context.Context) (SyncSession, error) {
+	client, err := s.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &firestoreSession{client: client, cfg: s.cfg}, nil
+}
+
+// firestoreSession is a Firestore connection scoped to a single
+// incremental sync.
+type firestoreSession struct {
+	client *firestore.Client
+	cfg    Config
+}
+
+// Close releases the underlying Firestore client.
+func (s *firestoreSession) Close() error {
+	return s.client.Close()
+}
+
+// metaDocRef is the document incremental syncs use to remember the
+// upstream's caching headers between invocations.
+func (s *firestoreSession) metaDocRef() *firestore.DocumentRef {
+	return s.client.Collection("_meta").Doc("posts")
+}
+
+// GetSyncMeta implements MetaStore. A missing document (the first sync)
+// returns a zero SyncMeta rather than an error.
+func (s *firestoreSession) GetSyncMeta(ctx context.Context) (SyncMeta, error) {
+	snap, err := s.metaDocRef().Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return SyncMeta{}, nil
+	}
+	if err != nil {
+		return SyncMeta{}, fmt.Errorf("reading sync meta: %v", err)
+	}
+
+	var meta SyncMeta
+	if err := snap.DataTo(&meta); err != nil {
+		return SyncMeta{}, fmt.Errorf("decoding sync meta: %v", err)
+	}
+	return meta, nil
+}
+
+// SetSyncMeta implements MetaStore.
+func (s *firestoreSession) SetSyncMeta(ctx context.Context, meta SyncMeta) error {
+	if _, err := s.metaDocRef().Set(ctx, meta); err != nil {
+		return fmt.Errorf("writing sync meta: %v", err)
+	}
+	return nil
+}
+
+// postDoc is the Firestore representation of a record in incremental mode:
+// the record itself plus the hash it was last written with.
+type postDoc struct {
+	APIResponse
+	Hash string `firestore:"hash"`
+}
+
+// recordHash returns the SHA-256 hash of the canonical JSON encoding of
+// record, used to detect whether a record changed since the last sync.
+func recordHash(record APIResponse) (string, error) {
+	canonical, err := json.Marshal(record)
+	if err != nil {
+		return "", fmt.Errorf("marshalling record %d: %v", record.ID, err)
+	}
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// StoreIncremental implements IncrementalSink. Each record is written in
+// its own transaction that reads the prior hash and only issues a Set when
+// the hash differs, so unchanged records cost a read instead of a write.
+func (s *firestoreSession) StoreIncremental(ctx context.Context, records []APIResponse) (WriteSummary, error) {
+	var (
+		mu      sync.Mutex
+		summary WriteSummary
+		wg      sync.WaitGroup
+	)
+	sem := make(chan struct{}, s.cfg.MaxInFlight)
+
+	for _, record := range records {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(record APIResponse) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			docID := strconv.Itoa(record.ID)
+			writeErr := s.storeIfChanged(ctx, docID, record)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if writeErr != nil {
+				summary.Failed = append(summary.Failed, WriteResult{DocID: docID, Err: writeErr})
+			} else {
+				summary.Succeeded = append(summary.Succeeded, docID)
+			}
+		}(record)
+	}
+	wg.Wait()
+
+	return summary, nil
+}
+
+// storeIfChanged writes record to docID only if its hash differs from the
+// hash stored there, reading and writing atomically within a transaction.
+func (s *firestoreSession) storeIfChanged(ctx context.Context, docID string, record APIResponse) error {
+	hash, err := recordHash(record)
+	if err != nil {
+		return err
+	}
+	ref := s.client.Collection("posts").Doc(docID)
+
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return fmt.Errorf("reading prior hash for %s: %v", docID, err)
+		}
+		if err == nil {
+			var existing postDoc
+			if decodeErr := snap.DataTo(&existing); decodeErr == nil && existing.Hash == hash {
+				return nil
+			}
+		}
+		return tx.Set(ref, postDoc{APIResponse: record, Hash: hash})
+	})
+}
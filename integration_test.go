@@ -0,0 +1,432 @@
+//go:build integration
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// startFirestoreEmulator boots the Cloud SDK emulator image, maps its
+// Firestore port and points FIRESTORE_EMULATOR_HOST/FIRESTORE_PROJECT at it
+// for the duration of the test.
+func startFirestoreEmulator(t *testing.T) (*firestore.Client, func()) {
+	t.Helper()
+	ctx := context.Background()
+
+	req := testcontainers.ContainerRequest{
+		Image:        "gcr.io/google.com/cloudsdktool/cloud-sdk:emulators",
+		ExposedPorts: []string{"8080/tcp"},
+		Cmd: []string{
+			"gcloud", "emulators", "firestore", "start",
+			"--host-port=0.0.0.0:8080",
+		},
+		WaitingFor: wait.ForLog("running").WithStartupTimeout(60 * time.Second),
+	}
+
+	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: req,
+		Started:          true,
+	})
+	if err != nil {
+		t.Fatalf("starting firestore emulator: %v", err)
+	}
+
+	host, err := container.Host(ctx)
+	if err != nil {
+		t.Fatalf("resolving emulator host: %v", err)
+	}
+	port, err := container.MappedPort(ctx, "8080")
+	if err != nil {
+		t.Fatalf("resolving emulator port: %v", err)
+	}
+
+	emulatorAddr := fmt.Sprintf("%s:%s", host, port.Port())
+	os.Setenv("FIRESTORE_EMULATOR_HOST", emulatorAddr)
+	os.Setenv("FIRESTORE_PROJECT", "test-project")
+
+	client, err := firestore.NewClient(ctx, "test-project")
+	if err != nil {
+		t.Fatalf("creating firestore client against emulator: %v", err)
+	}
+
+	cleanup := func() {
+		client.Close()
+		os.Unsetenv("FIRESTORE_EMULATOR_HOST")
+		os.Unsetenv("FIRESTORE_PROJECT")
+		if err := container.Terminate(ctx); err != nil {
+			t.Logf("terminating emulator container: %v", err)
+		}
+	}
+	return client, cleanup
+}
+
+// stubJSONPlaceholder serves a fixed set of posts in place of the real
+// JSONPlaceholder API and points defaultSource at it for the duration of
+// the test.
+func stubJSONPlaceholder(t *testing.T, posts []APIResponse, status int) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		if posts != nil {
+			json.NewEncoder(w).Encode(posts)
+		}
+	}))
+
+	previous := defaultSource
+	defaultSource = jsonPlaceholderSource{url: server.URL}
+	t.Cleanup(func() {
+		defaultSource = previous
+		server.Close()
+	})
+	return server
+}
+
+func TestIntegration_FullFlowRoundTrip(t *testing.T) {
+	client, cleanupEmulator := startFirestoreEmulator(t)
+	defer cleanupEmulator()
+
+	want := []APIResponse{
+		{ID: 1, Title: "first post", Body: "first body"},
+		{ID: 2, Title: "second post", Body: "second body"},
+	}
+	stubJSONPlaceholder(t, want, http.StatusOK)
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	FunctionEntryPoint(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("FunctionEntryPoint returned status %d: %s", rr.Code, rr.Body.String())
+	}
+
+	ctx := context.Background()
+	for _, post := range want {
+		docID := fmt.Sprintf("%d", post.ID)
+		snap, err := client.Collection("posts").Doc(docID).Get(ctx)
+		if err != nil {
+			t.Fatalf("reading back post %s: %v", docID, err)
+		}
+		var got APIResponse
+		if err := snap.DataTo(&got); err != nil {
+			t.Fatalf("decoding post %s: %v", docID, err)
+		}
+		if got != post {
+			t.Errorf("post %s round-tripped as %+v, want %+v", docID, got, post)
+		}
+	}
+}
+
+func TestIntegration_EmulatorDown(t *testing.T) {
+	os.Setenv("FIRESTORE_EMULATOR_HOST", "127.0.0.1:1")
+	os.Setenv("FIRESTORE_PROJECT", "test-project")
+	defer os.Unsetenv("FIRESTORE_EMULATOR_HOST")
+	defer os.Unsetenv("FIRESTORE_PROJECT")
+
+	stubJSONPlaceholder(t, []APIResponse{{ID: 1, Title: "t", Body: "b"}}, http.StatusOK)
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	FunctionEntryPoint(rr, req)
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500 with emulator down, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestIntegration_UpstreamError(t *testing.T) {
+	_, cleanupEmulator := startFirestoreEmulator(t)
+	defer cleanupEmulator()
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	previous := defaultSource
+	defaultSource = jsonPlaceholderSource{url: server.URL}
+	defer func() { defaultSource = previous }()
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	FunctionEntryPoint(rr, req)
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500 on upstream error, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if atomic.LoadInt32(&requests) == 0 {
+		t.Fatal("expected the upstream stub to be contacted, but it wasn't")
+	}
+}
+
+func TestIntegration_MalformedUpstreamJSON(t *testing.T) {
+	_, cleanupEmulator := startFirestoreEmulator(t)
+	defer cleanupEmulator()
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, "not json")
+	}))
+	defer server.Close()
+	previous := defaultSource
+	defaultSource = jsonPlaceholderSource{url: server.URL}
+	defer func() { defaultSource = previous }()
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	FunctionEntryPoint(rr, req)
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500 on malformed JSON, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if atomic.LoadInt32(&requests) == 0 {
+		t.Fatal("expected the upstream stub to be contacted, but it wasn't")
+	}
+}
+
+func TestIntegration_IncrementalSyncSkipsUnchanged(t *testing.T) {
+	client, cleanupEmulator := startFirestoreEmulator(t)
+	defer cleanupEmulator()
+
+	posts := []APIResponse{{ID: 1, Title: "first post", Body: "first body"}}
+	var requests, notModified int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(posts)
+	}))
+	defer server.Close()
+
+	previous := defaultSource
+	defaultSource = jsonPlaceholderSource{url: server.URL}
+	defer func() { defaultSource = previous }()
+
+	for i := 0; i < 2; i++ {
+		rr := httptest.NewRecorder()
+		FunctionEntryPoint(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("sync %d failed: %d %s", i, rr.Code, rr.Body.String())
+		}
+	}
+
+	if got := atomic.LoadInt32(&requests); got != 2 {
+		t.Fatalf("expected 2 upstream requests (one per sync), got %d", got)
+	}
+	if got := atomic.LoadInt32(&notModified); got != 1 {
+		t.Fatalf("expected the second sync to be answered 304 Not Modified, got %d such responses", got)
+	}
+
+	ctx := context.Background()
+	snap, err := client.Collection("posts").Doc("1").Get(ctx)
+	if err != nil {
+		t.Fatalf("reading back post 1: %v", err)
+	}
+	var got APIResponse
+	if err := snap.DataTo(&got); err != nil {
+		t.Fatalf("decoding post 1: %v", err)
+	}
+	if got != posts[0] {
+		t.Errorf("post 1 = %+v, want %+v", got, posts[0])
+	}
+}
+
+func TestIntegration_ForceBypassesCache(t *testing.T) {
+	_, cleanupEmulator := startFirestoreEmulator(t)
+	defer cleanupEmulator()
+
+	posts := []APIResponse{{ID: 1, Title: "first post", Body: "first body"}}
+	var requests, conditional int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != "" {
+			atomic.AddInt32(&conditional, 1)
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(posts)
+	}))
+	defer server.Close()
+
+	previous := defaultSource
+	defaultSource = jsonPlaceholderSource{url: server.URL}
+	defer func() { defaultSource = previous }()
+
+	for i := 0; i < 2; i++ {
+		rr := httptest.NewRecorder()
+		FunctionEntryPoint(rr, httptest.NewRequest(http.MethodGet, "/?force=1", nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("sync %d failed: %d %s", i, rr.Code, rr.Body.String())
+		}
+	}
+
+	if got := atomic.LoadInt32(&requests); got != 2 {
+		t.Fatalf("force=1 should bypass the cache on every call, got %d upstream requests", got)
+	}
+	if got := atomic.LoadInt32(&conditional); got != 0 {
+		t.Fatalf("force=1 should send unconditional requests, got %d conditional ones", got)
+	}
+}
+
+func TestIntegration_QueryAPI(t *testing.T) {
+	_, cleanupEmulator := startFirestoreEmulator(t)
+	defer cleanupEmulator()
+
+	seed := []APIResponse{
+		{ID: 1, Title: "first post", Body: "first body"},
+		{ID: 2, Title: "second post", Body: "second body"},
+		{ID: 3, Title: "third post", Body: "third body"},
+	}
+	sink := firestoreSink{cfg: DefaultConfig()}
+	if _, err := sink.Store(context.Background(), seed); err != nil {
+		t.Fatalf("seeding posts: %v", err)
+	}
+
+	router := newQueryRouter(sink)
+
+	t.Run("get by id", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/posts/2", nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var got APIResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+			t.Fatalf("decoding response: %v", err)
+		}
+		if got != seed[1] {
+			t.Errorf("got %+v, want %+v", got, seed[1])
+		}
+	})
+
+	t.Run("get by id not found", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/posts/999", nil))
+		if rr.Code != http.StatusNotFound {
+			t.Fatalf("expected 404, got %d: %s", rr.Code, rr.Body.String())
+		}
+	})
+
+	t.Run("list paginates with limit and pageToken", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/posts?limit=2", nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var page postsPage
+		if err := json.Unmarshal(rr.Body.Bytes(), &page); err != nil {
+			t.Fatalf("decoding page: %v", err)
+		}
+		if len(page.Items) != 2 || page.NextPageToken == "" {
+			t.Fatalf("expected a full page with a next token, got %+v", page)
+		}
+
+		rr = httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/posts?limit=2&pageToken="+page.NextPageToken, nil)
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var page2 postsPage
+		if err := json.Unmarshal(rr.Body.Bytes(), &page2); err != nil {
+			t.Fatalf("decoding page 2: %v", err)
+		}
+		if len(page2.Items) != 1 || page2.Items[0] != seed[2] {
+			t.Fatalf("expected the last remaining post, got %+v", page2)
+		}
+	})
+
+	t.Run("list filters by title", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/posts?title="+url.QueryEscape(seed[1].Title), nil)
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var page postsPage
+		if err := json.Unmarshal(rr.Body.Bytes(), &page); err != nil {
+			t.Fatalf("decoding page: %v", err)
+		}
+		if len(page.Items) != 1 || page.Items[0] != seed[1] {
+			t.Fatalf("expected exactly the matching post, got %+v", page)
+		}
+	})
+
+	t.Run("list streams NDJSON when requested", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/posts?limit=10", nil)
+		req.Header.Set("Accept", "application/x-ndjson")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if ct := rr.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+			t.Fatalf("expected ndjson content type, got %q", ct)
+		}
+		lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+		if len(lines) != len(seed) {
+			t.Fatalf("expected %d NDJSON lines, got %d: %q", len(seed), len(lines), rr.Body.String())
+		}
+		for i, line := range lines {
+			var envelope ndjsonLine
+			if err := json.Unmarshal([]byte(line), &envelope); err != nil {
+				t.Fatalf("decoding NDJSON line %d: %v", i, err)
+			}
+			if envelope.Post == nil || envelope.NextPageToken != "" {
+				t.Fatalf("expected line %d to be a post, got %+v", i, envelope)
+			}
+		}
+	})
+
+	t.Run("list terminates a full NDJSON page with a typed cursor line", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/posts?limit=%d", len(seed)), nil)
+		req.Header.Set("Accept", "application/x-ndjson")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+
+		lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+		if len(lines) != len(seed)+1 {
+			t.Fatalf("expected %d post lines plus a cursor line, got %d: %q", len(seed), len(lines), rr.Body.String())
+		}
+
+		var last ndjsonLine
+		if err := json.Unmarshal([]byte(lines[len(lines)-1]), &last); err != nil {
+			t.Fatalf("decoding cursor line: %v", err)
+		}
+		if last.Post != nil || last.NextPageToken == "" {
+			t.Fatalf("expected the last line to be a cursor envelope, got %+v", last)
+		}
+	})
+
+	t.Run("list rejects invalid limit", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/posts?limit=not-a-number", nil))
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400, got %d: %s", rr.Code, rr.Body.String())
+		}
+	})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// SyncMeta captures the caching headers persisted between invocations so
+// an incremental sync can ask the upstream for only what changed.
+type SyncMeta struct {
+	ETag         string `firestore:"etag"`
+	LastModified string `firestore:"lastModified"`
+}
+
+// jsonPlaceholderSource is a Source that fetches posts from a
+// JSONPlaceholder-compatible endpoint.
+type jsonPlaceholderSource struct {
+	url string
+}
+
+// Fetch implements Source.
+func (s jsonPlaceholderSource) Fetch(ctx context.Context) ([]APIResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("building request: %v", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching data: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	var data []APIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, fmt.Errorf("error decoding response: %v", err)
+	}
+	return data, nil
+}
+
+// FetchConditional implements ConditionalSource. It sends
+// If-None-Match/If-Modified-Since based on meta and reports notModified
+// when the upstream responds 304, in which case records is nil and meta
+// is returned unchanged.
+func (s jsonPlaceholderSource) FetchConditional(ctx context.Context, meta SyncMeta) (records []APIResponse, newMeta SyncMeta, notModified bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, SyncMeta{}, false, fmt.Errorf("building request: %v", err)
+	}
+	if meta.ETag != "" {
+		req.Header.Set("If-None-Match", meta.ETag)
+	}
+	if meta.LastModified != "" {
+		req.Header.Set("If-Modified-Since", meta.LastModified)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, SyncMeta{}, false, fmt.Errorf("error fetching data: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, meta, true, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, SyncMeta{}, false, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	var data []APIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, SyncMeta{}, false, fmt.Errorf("error decoding response: %v", err)
+	}
+
+	newMeta = SyncMeta{ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}
+	return data, newMeta, false, nil
+}
@@ -6,84 +6,131 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"os"
-	"strconv"
 
-	"cloud.google.com/go/firestore"
+	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
 )
 
-// APIResponse defines the structure of the API response
+// APIResponse defines the structure of a single ingested record.
 type APIResponse struct {
-	ID    int    `json:"id"` // ID is now an integer
-	Title string `json:"title"`
-	Body  string `json:"body"`
+	ID    int    `json:"id" firestore:"id"` // ID is now an integer
+	Title string `json:"title" firestore:"title"`
+	Body  string `json:"body" firestore:"body"`
 }
 
-// fetchData fetches posts from the JSONPlaceholder API
-func fetchData() ([]APIResponse, error) {
-	resp, err := http.Get("https://jsonplaceholder.typicode.com/posts")
-	if err != nil {
-		return nil, fmt.Errorf("error fetching data: %v", err)
-	}
-	defer resp.Body.Close()
+// Source fetches the records a function invocation should ingest.
+type Source interface {
+	Fetch(ctx context.Context) ([]APIResponse, error)
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
-	}
+// Sink persists fetched records and reports which ones succeeded or
+// failed.
+type Sink interface {
+	Store(ctx context.Context, records []APIResponse) (WriteSummary, error)
+}
 
-	var data []APIResponse
-	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
-		return nil, fmt.Errorf("error decoding response: %v", err)
-	}
-	return data, nil
+// ConditionalSource is a Source that can skip the fetch entirely when the
+// upstream resource is unchanged since the last sync.
+type ConditionalSource interface {
+	Source
+	FetchConditional(ctx context.Context, meta SyncMeta) (records []APIResponse, newMeta SyncMeta, notModified bool, err error)
 }
 
-// saveToFirestore saves the fetched data to Firestore
-func saveToFirestore(data []APIResponse) error {
-	projectID := os.Getenv("FIRESTORE_PROJECT")
-	if projectID == "" {
-		return fmt.Errorf("FIRESTORE_PROJECT environment variable not set")
-	}
+// MetaStore persists the SyncMeta used to make conditional requests.
+type MetaStore interface {
+	GetSyncMeta(ctx context.Context) (SyncMeta, error)
+	SetSyncMeta(ctx context.Context, meta SyncMeta) error
+}
 
-	ctx := context.Background()
+// IncrementalSink is a Sink that can skip writing records whose content
+// hasn't changed since the last sync.
+type IncrementalSink interface {
+	StoreIncremental(ctx context.Context, records []APIResponse) (WriteSummary, error)
+}
 
-	// Create Firestore client
-	client, err := firestore.NewClient(ctx, projectID)
-	if err != nil {
-		return fmt.Errorf("firestore client creation failed: %v", err)
-	}
-	defer client.Close()
+// SyncSession bundles the meta and incremental-write operations of one
+// incremental sync so they can share a single underlying connection.
+type SyncSession interface {
+	MetaStore
+	IncrementalSink
+	Close() error
+}
 
-	// Log emulator usage if applicable
-	if emulatorHost := os.Getenv("FIRESTORE_EMULATOR_HOST"); emulatorHost != "" {
-		log.Printf("Using Firestore emulator at %s", emulatorHost)
-	}
+// ConnectableSink is a Sink that can open a SyncSession, dialing its
+// underlying store once for the whole sync instead of once per operation.
+type ConnectableSink interface {
+	Connect(ctx context.Context) (SyncSession, error)
+}
+
+// defaultSource and defaultSink wire up the original JSONPlaceholder ->
+// Firestore pipeline. Both entry points below use them unless a request
+// carries its own target.
+var (
+	defaultSource Source = jsonPlaceholderSource{url: "https://jsonplaceholder.typicode.com/posts"}
+	defaultSink   Sink   = firestoreSink{cfg: DefaultConfig()}
+)
+
+// orchestrate fetches records from source and hands them to sink. When
+// source and sink support it, and force is false, it runs an incremental
+// sync: the upstream fetch is made conditional on the last-seen
+// ETag/Last-Modified, and a 304 short-circuits the whole call. Otherwise
+// it falls back to a full fetch and write.
+func orchestrate(ctx context.Context, source Source, sink Sink, force bool) (WriteSummary, error) {
+	condSource, hasConditionalSource := source.(ConditionalSource)
+	connectableSink, hasConnectableSink := sink.(ConnectableSink)
+
+	if !force && hasConditionalSource && hasConnectableSink {
+		session, err := connectableSink.Connect(ctx)
+		if err != nil {
+			return WriteSummary{}, fmt.Errorf("connecting sink: %w", err)
+		}
+		defer session.Close()
+
+		meta, err := session.GetSyncMeta(ctx)
+		if err != nil {
+			return WriteSummary{}, fmt.Errorf("loading sync meta: %w", err)
+		}
+
+		records, newMeta, notModified, err := condSource.FetchConditional(ctx, meta)
+		if err != nil {
+			return WriteSummary{}, fmt.Errorf("fetching records: %w", err)
+		}
+		if notModified {
+			return WriteSummary{}, nil
+		}
 
-	// Save data to Firestore
-	for _, record := range data {
-		docID := strconv.Itoa(record.ID) // Convert int ID to string
-		_, err := client.Collection("posts").Doc(docID).Set(ctx, record)
+		summary, err := session.StoreIncremental(ctx, records)
 		if err != nil {
-			return fmt.Errorf("error saving record ID %d: %v", record.ID, err)
+			return WriteSummary{}, err
 		}
+		if err := session.SetSyncMeta(ctx, newMeta); err != nil {
+			return summary, fmt.Errorf("saving sync meta: %w", err)
+		}
+		return summary, nil
 	}
-	return nil
+
+	records, err := source.Fetch(ctx)
+	if err != nil {
+		return WriteSummary{}, fmt.Errorf("fetching records: %w", err)
+	}
+	return sink.Store(ctx, records)
 }
 
-// FunctionEntryPoint handles HTTP requests and processes data
+// FunctionEntryPoint handles HTTP requests and processes data. Pass
+// ?force=1 to bypass the incremental-sync cache and re-fetch and rewrite
+// every record.
 func FunctionEntryPoint(w http.ResponseWriter, r *http.Request) {
-	// Fetch data from the external API
-	data, err := fetchData()
+	force := r.URL.Query().Get("force") == "1"
+	summary, err := orchestrate(r.Context(), defaultSource, defaultSink, force)
 	if err != nil {
-		log.Printf("Error fetching data: %v", err)
-		http.Error(w, "Failed to fetch data", http.StatusInternalServerError)
+		log.Printf("Error processing data: %v", err)
+		http.Error(w, "Failed to process data", http.StatusInternalServerError)
 		return
 	}
 
-	// Save data to Firestore
-	if err := saveToFirestore(data); err != nil {
-		log.Printf("Error saving data: %v", err)
-		http.Error(w, "Failed to save data", http.StatusInternalServerError)
+	if len(summary.Failed) > 0 {
+		log.Printf("saved %d records, failed %d: %+v", len(summary.Succeeded), len(summary.Failed), summary.Failed)
+		http.Error(w, fmt.Sprintf("Failed to save %d of %d records", len(summary.Failed), len(summary.Succeeded)+len(summary.Failed)), http.StatusInternalServerError)
 		return
 	}
 
@@ -92,6 +139,64 @@ func FunctionEntryPoint(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintln(w, "Data successfully processed and stored!")
 }
 
+// MessagePublishedData is the CloudEvent payload Pub/Sub and Cloud
+// Scheduler (via a Pub/Sub topic) deliver.
+type MessagePublishedData struct {
+	Message PubSubMessage
+}
+
+// PubSubMessage holds the raw Pub/Sub message body.
+type PubSubMessage struct {
+	Data []byte `json:"data"`
+}
+
+// pubsubPayload is the optional JSON body of a Pub/Sub message, used to
+// override the default source URL and to force a full, non-incremental
+// sync.
+type pubsubPayload struct {
+	URL   string `json:"url"`
+	Force bool   `json:"force"`
+}
+
+// FunctionPubSub handles a Pub/Sub or Cloud Scheduler CloudEvent,
+// optionally targeting a different source URL carried in the message
+// payload, and runs the same fetch/store pipeline as FunctionEntryPoint.
+func FunctionPubSub(ctx context.Context, e cloudevents.Event) error {
+	var msg MessagePublishedData
+	if err := e.DataAs(&msg); err != nil {
+		return fmt.Errorf("event.DataAs: %v", err)
+	}
+
+	source := defaultSource
+	var force bool
+	if len(msg.Message.Data) > 0 {
+		var payload pubsubPayload
+		if err := json.Unmarshal(msg.Message.Data, &payload); err != nil {
+			return fmt.Errorf("unmarshalling pubsub payload: %v", err)
+		}
+		if payload.URL != "" {
+			source = jsonPlaceholderSource{url: payload.URL}
+		}
+		force = payload.Force
+	}
+
+	summary, err := orchestrate(ctx, source, defaultSink, force)
+	if err != nil {
+		return err
+	}
+	if len(summary.Failed) > 0 {
+		return fmt.Errorf("failed to save %d of %d records", len(summary.Failed), len(summary.Succeeded)+len(summary.Failed))
+	}
+
+	log.Printf("saved %d records", len(summary.Succeeded))
+	return nil
+}
+
+func init() {
+	functions.HTTP("FunctionEntryPoint", FunctionEntryPoint)
+	functions.CloudEvent("FunctionPubSub", FunctionPubSub)
+}
+
 // main runs the server locally
 func main() {
 	http.HandleFunc("/", FunctionEntryPoint)
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
+	"github.com/go-chi/chi/v5"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// queryHandler serves read-only access to the posts collection: GET
+// /posts, GET /posts/{id} and GET /posts?title=...&limit=N&pageToken=...
+type queryHandler struct {
+	sink firestoreSink
+}
+
+// newQueryRouter builds the chi router queryHandler's endpoints are
+// mounted on.
+func newQueryRouter(sink firestoreSink) http.Handler {
+	h := &queryHandler{sink: sink}
+	r := chi.NewRouter()
+	r.Get("/posts", h.listPosts)
+	r.Get("/posts/{id}", h.getPost)
+	return r
+}
+
+// errorResponse is the JSON body written on request failures.
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse{Error: message})
+}
+
+// wantsNDJSON reports whether the client asked for newline-delimited JSON
+// instead of a single JSON array.
+func wantsNDJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/x-ndjson")
+}
+
+// encodePageToken and decodePageToken turn the last-seen post ID of a page
+// into an opaque cursor for the next request. The ID is the only thing
+// encoded, so the token is only "opaque" by convention, not by secrecy.
+func encodePageToken(lastID int) string {
+	return base64.URLEncoding.EncodeToString([]byte(strconv.Itoa(lastID)))
+}
+
+func decodePageToken(token string) (int, error) {
+	raw, err := base64.URLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, fmt.Errorf("invalid page token")
+	}
+	id, err := strconv.Atoi(string(raw))
+	if err != nil {
+		return 0, fmt.Errorf("invalid page token")
+	}
+	return id, nil
+}
+
+// getPost handles GET /posts/{id}.
+func (h *queryHandler) getPost(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "id must be an integer")
+		return
+	}
+
+	client, err := h.sink.client(ctx)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to connect to firestore")
+		return
+	}
+	defer client.Close()
+
+	snap, err := client.Collection("posts").Doc(strconv.Itoa(id)).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("post %d not found", id))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to read post")
+		return
+	}
+
+	var post APIResponse
+	if err := snap.DataTo(&post); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to decode post")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(post)
+}
+
+// listPosts handles GET /posts, with optional title filtering and
+// StartAfter-cursor pagination.
+func (h *queryHandler) listPosts(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	limit := defaultPageSize
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 {
+			writeError(w, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+		limit = parsed
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+
+	var (
+		afterID  int
+		hasAfter bool
+	)
+	if token := r.URL.Query().Get("pageToken"); token != "" {
+		id, err := decodePageToken(token)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		afterID, hasAfter = id, true
+	}
+
+	client, err := h.sink.client(ctx)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to connect to firestore")
+		return
+	}
+	defer client.Close()
+
+	query := client.Collection("posts").OrderBy("id", firestore.Asc)
+	if title := r.URL.Query().Get("title"); title != "" {
+		// Requires a composite index on (title asc/eq, id asc) in Firestore.
+		query = query.Where("title", "==", title)
+	}
+	if hasAfter {
+		query = query.StartAfter(afterID)
+	}
+	query = query.Limit(limit)
+
+	it := query.Documents(ctx)
+	defer it.Stop()
+
+	if wantsNDJSON(r) {
+		h.streamNDJSON(w, it, limit)
+		return
+	}
+	h.writeJSONPage(w, it, limit)
+}
+
+// ndjsonLine is one line of a streamed NDJSON response. Exactly one of
+// Post or NextPageToken is set, so a client decoding each line can tell a
+// record from the trailing cursor instead of getting a zero-valued
+// APIResponse for it.
+type ndjsonLine struct {
+	Post          *APIResponse `json:"post,omitempty"`
+	NextPageToken string       `json:"nextPageToken,omitempty"`
+}
+
+// streamNDJSON writes one envelope per post as it is read from the
+// Firestore iterator, without buffering the whole page, followed by a
+// trailing envelope carrying the next page token if the page was full.
+func (h *queryHandler) streamNDJSON(w http.ResponseWriter, it *firestore.DocumentIterator, limit int) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+
+	var (
+		lastID int
+		seen   int
+	)
+	for {
+		doc, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			log.Printf("iterating posts: %v", err)
+			return
+		}
+		seen++
+		if id, convErr := strconv.Atoi(doc.Ref.ID); convErr == nil {
+			lastID = id
+		}
+
+		var post APIResponse
+		if err := doc.DataTo(&post); err != nil {
+			log.Printf("decoding post %s: %v", doc.Ref.ID, err)
+			continue
+		}
+
+		enc.Encode(ndjsonLine{Post: &post})
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	if seen == limit {
+		enc.Encode(ndjsonLine{NextPageToken: encodePageToken(lastID)})
+	}
+}
+
+// postsPage is the JSON body written when the client did not ask for
+// NDJSON.
+type postsPage struct {
+	Items         []APIResponse `json:"items"`
+	NextPageToken string        `json:"nextPageToken,omitempty"`
+}
+
+// writeJSONPage buffers a single page (bounded by limit, never the whole
+// collection) and writes it as one JSON object.
+func (h *queryHandler) writeJSONPage(w http.ResponseWriter, it *firestore.DocumentIterator, limit int) {
+	items := make([]APIResponse, 0, limit)
+	var (
+		lastID int
+		seen   int
+	)
+
+	for {
+		doc, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to read posts")
+			return
+		}
+		seen++
+		if id, convErr := strconv.Atoi(doc.Ref.ID); convErr == nil {
+			lastID = id
+		}
+
+		var post APIResponse
+		if err := doc.DataTo(&post); err != nil {
+			log.Printf("decoding post %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		items = append(items, post)
+	}
+
+	page := postsPage{Items: items}
+	if seen == limit {
+		page.NextPageToken = encodePageToken(lastID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// queryRouter is the HTTP entry point registered as FunctionQuery, serving
+// the read-only posts API backed by Firestore.
+var queryRouter = newQueryRouter(firestoreSink{cfg: DefaultConfig()})
+
+func init() {
+	functions.HTTP("FunctionQuery", queryRouter.ServeHTTP)
+}